@@ -0,0 +1,69 @@
+package stor
+
+// CacheChain composes multiple caches into tiers, e.g. a small in-memory
+// CacheMostRecent in front of a larger CacheLFU, or an in-memory cache in
+// front of a second on-disk cache. Layers are ordered fastest/smallest
+// first. On Get, layers are walked in order and a hit is promoted into
+// every earlier (faster) layer that implements RetrievalCache, so
+// repeated lookups are served from the front of the chain. SeePut and SeeGet are fanned out to every layer
+// that implements the corresponding sub-interface.
+type CacheChain struct {
+	layers []Cache
+}
+
+// NewCacheChain builds a chain from layers ordered fastest/smallest first
+func NewCacheChain(layers ...Cache) *CacheChain {
+	if len(layers) == 0 {
+		return nil
+	}
+
+	return &CacheChain{layers: layers}
+}
+
+// Get walks the chain in order and promotes a hit into every earlier layer
+// that implements RetrievalCache
+func (c *CacheChain) Get(hash string) (data []byte) {
+	for i, layer := range c.layers {
+		data = layer.Get(hash)
+		if data == nil {
+			continue
+		}
+
+		for _, earlier := range c.layers[:i] {
+			if getCache, ok := earlier.(RetrievalCache); ok {
+				getCache.SeeGet(hash, data)
+			}
+		}
+
+		return data
+	}
+
+	return nil
+}
+
+// SeeGet fans out to every layer that implements RetrievalCache
+func (c *CacheChain) SeeGet(hash string, data []byte) {
+	for _, layer := range c.layers {
+		if getCache, ok := layer.(RetrievalCache); ok {
+			getCache.SeeGet(hash, data)
+		}
+	}
+}
+
+// SeePut fans out to every layer that implements InsertionCache
+func (c *CacheChain) SeePut(hash string, data []byte) {
+	for _, layer := range c.layers {
+		if putCache, ok := layer.(InsertionCache); ok {
+			putCache.SeePut(hash, data)
+		}
+	}
+}
+
+// Evict fans out to every layer that implements Evictor
+func (c *CacheChain) Evict(hash string) {
+	for _, layer := range c.layers {
+		if evictor, ok := layer.(Evictor); ok {
+			evictor.Evict(hash)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package stor
+
+import (
+	"container/list"
+	"sync"
+)
+
+// an lfuEntry is a single cached value together with the bookkeeping
+// needed to locate it within its current frequency bucket
+type lfuEntry struct {
+	hash      string
+	data      []byte
+	freq      int
+	freqElem  *list.Element // element in CacheLFU.freqs, Value is *lfuFreqNode
+	entryElem *list.Element // element in freqElem's entries list, Value is this *lfuEntry
+}
+
+// an lfuFreqNode buckets all entries sharing the same access frequency.
+// freqs is kept sorted by ascending freq so the minimum-frequency bucket
+// is always at the front
+type lfuFreqNode struct {
+	freq    int
+	entries *list.List // of *lfuEntry, most recently touched at the front
+}
+
+// Least Frequently Used (LFU) eviction policy, bounded by total cached
+// bytes rather than entry count. Entries are bucketed by access
+// frequency (the classic O(1) LFU structure: a doubly linked list of
+// frequency nodes, each holding a doubly linked list of entries) so Get
+// and eviction remain O(1) amortized. When the byte budget is exceeded,
+// entries are evicted from the lowest frequency bucket first, and within
+// a bucket the least recently touched entry goes first.
+type CacheLFU struct {
+	m        map[string]*lfuEntry
+	freqs    *list.List // of *lfuFreqNode, ascending by freq
+	maxBytes int64
+	bytes    int64
+	mutex    sync.RWMutex
+}
+
+func NewCacheLFU(maxBytes int64) *CacheLFU {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	return &CacheLFU{
+		m:        make(map[string]*lfuEntry),
+		freqs:    list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+// Bytes returns the total number of bytes currently cached
+func (c *CacheLFU) Bytes() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.bytes
+}
+
+// Len returns the number of entries currently cached
+func (c *CacheLFU) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.m)
+}
+
+// freqNodeAfter returns the freq node for freq, creating and linking it
+// directly after 'after' if it doesn't already exist
+func (c *CacheLFU) freqNodeAfter(after *list.Element, freq int) *list.Element {
+	if after == nil {
+		if front := c.freqs.Front(); front != nil && front.Value.(*lfuFreqNode).freq == freq {
+			return front
+		}
+
+		return c.freqs.PushFront(&lfuFreqNode{freq: freq, entries: list.New()})
+	}
+
+	if next := after.Next(); next != nil && next.Value.(*lfuFreqNode).freq == freq {
+		return next
+	}
+
+	return c.freqs.InsertAfter(&lfuFreqNode{freq: freq, entries: list.New()}, after)
+}
+
+// touch bumps an entry's frequency by one and moves it into the
+// (possibly newly created) next frequency bucket
+func (c *CacheLFU) touch(entry *lfuEntry) {
+	oldFreqElem := entry.freqElem
+	oldFreqNode := oldFreqElem.Value.(*lfuFreqNode)
+	oldFreqNode.entries.Remove(entry.entryElem)
+
+	newFreqElem := c.freqNodeAfter(oldFreqElem, entry.freq+1)
+	if oldFreqNode.entries.Len() == 0 {
+		c.freqs.Remove(oldFreqElem)
+	}
+
+	entry.freq++
+	newFreqNode := newFreqElem.Value.(*lfuFreqNode)
+	entry.entryElem = newFreqNode.entries.PushFront(entry)
+	entry.freqElem = newFreqElem
+}
+
+// evict removes entries from the lowest-frequency bucket, least
+// recently touched first, until the byte budget is satisfied
+func (c *CacheLFU) evict() {
+	for c.bytes > c.maxBytes {
+		freqElem := c.freqs.Front()
+		if freqElem == nil {
+			return
+		}
+
+		freqNode := freqElem.Value.(*lfuFreqNode)
+		victimElem := freqNode.entries.Back()
+		if victimElem == nil {
+			c.freqs.Remove(freqElem)
+			continue
+		}
+
+		c.removeEntry(victimElem.Value.(*lfuEntry))
+	}
+}
+
+// removeEntry drops entry from its frequency bucket and the cache
+func (c *CacheLFU) removeEntry(entry *lfuEntry) {
+	freqNode := entry.freqElem.Value.(*lfuFreqNode)
+	freqNode.entries.Remove(entry.entryElem)
+	if freqNode.entries.Len() == 0 {
+		c.freqs.Remove(entry.freqElem)
+	}
+
+	delete(c.m, entry.hash)
+	c.bytes -= int64(len(entry.data))
+}
+
+// Evict drops hash from the cache, if present
+func (c *CacheLFU) Evict(hash string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, exists := c.m[hash]; exists {
+		c.removeEntry(entry)
+	}
+}
+
+func (c *CacheLFU) insert(hash string, data []byte) {
+	if data == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, exists := c.m[hash]; exists {
+		c.bytes += int64(len(data)) - int64(len(entry.data))
+		entry.data = data
+		c.evict()
+		return
+	}
+
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	freqElem := c.freqNodeAfter(nil, 1)
+	entry := &lfuEntry{hash: hash, data: data, freq: 1, freqElem: freqElem}
+	freqNode := freqElem.Value.(*lfuFreqNode)
+	entry.entryElem = freqNode.entries.PushFront(entry)
+
+	c.m[hash] = entry
+	c.bytes += int64(len(data))
+	c.evict()
+}
+
+// See a store retrieval. This should only occur if the element
+// is not already in the cache
+func (c *CacheLFU) SeeGet(hash string, data []byte) {
+	c.insert(hash, data)
+}
+
+func (c *CacheLFU) SeePut(hash string, data []byte) {
+	c.insert(hash, data)
+}
+
+// get data from the cache, if any, bumping its frequency on a hit
+func (c *CacheLFU) Get(hash string) (data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.m[hash]
+	if !exists {
+		return nil
+	}
+
+	c.touch(entry)
+	return entry.data
+}
@@ -0,0 +1,192 @@
+package stor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expiry records a per-hash expiration time for a Store and is
+// consulted by Get and the background janitor started by
+// Store.StartJanitor.
+type Expiry interface {
+	SetExpiry(hash string, expiresAt time.Time) error
+	ExpiresAt(hash string) (expiresAt time.Time, ok bool)
+	ClearExpiry(hash string) error
+	// Iterate calls fn for every hash with a recorded expiration,
+	// stopping early if fn returns false
+	Iterate(fn func(hash string, expiresAt time.Time) bool) error
+}
+
+// FileExpiry persists each hash's expiration time as a sidecar file,
+// hash+".expires", alongside where FSStorage would store its data, so
+// expiry survives a restart.
+type FileExpiry struct {
+	Path string
+}
+
+func NewFileExpiry(path string) *FileExpiry {
+	return &FileExpiry{Path: path}
+}
+
+func (e *FileExpiry) metaPath(hash string) string {
+	return filepath.Join(fsHashDir(e.Path, hash), hash+".expires")
+}
+
+func (e *FileExpiry) SetExpiry(hash string, expiresAt time.Time) error {
+	dir := fsHashDir(e.Path, hash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(expiresAt.Unix(), 10)
+	return ioutil.WriteFile(e.metaPath(hash), []byte(ts), 0644)
+}
+
+func (e *FileExpiry) ExpiresAt(hash string) (expiresAt time.Time, ok bool) {
+	data, err := ioutil.ReadFile(e.metaPath(hash))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0), true
+}
+
+func (e *FileExpiry) ClearExpiry(hash string) error {
+	err := os.Remove(e.metaPath(hash))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (e *FileExpiry) Iterate(fn func(hash string, expiresAt time.Time) bool) error {
+	subdirs, err := ioutil.ReadDir(e.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(e.Path, subdir.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			if !strings.HasSuffix(name, ".expires") {
+				continue
+			}
+
+			hash := strings.TrimSuffix(name, ".expires")
+			expiresAt, ok := e.ExpiresAt(hash)
+			if !ok {
+				continue
+			}
+
+			if !fn(hash, expiresAt) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// PutWithTTL puts an element like Put, additionally recording an
+// expiration time via s.Expiry. Get treats the element as absent once
+// its TTL has passed, even before StartJanitor's background sweep has
+// gotten around to removing it.
+func (s *Store) PutWithTTL(hash string, data []byte, ttl time.Duration) error {
+	if err := s.Put(hash, data); err != nil {
+		return err
+	}
+
+	if s.Expiry != nil {
+		return s.Expiry.SetExpiry(hash, time.Now().Add(ttl))
+	}
+
+	return nil
+}
+
+// StartJanitor starts a background goroutine that, every interval,
+// removes entries whose TTL has passed from storage, the inventory, and
+// the cache. It's a no-op loop if s.Expiry is unset. It returns a stop
+// function the caller must call to release the goroutine.
+func (s *Store) StartJanitor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweepExpired()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// sweepExpired removes every hash whose expiration time has passed
+func (s *Store) sweepExpired() {
+	if s.Expiry == nil {
+		return
+	}
+
+	now := time.Now()
+	var expired []string
+	s.Expiry.Iterate(func(hash string, expiresAt time.Time) bool {
+		if !now.Before(expiresAt) {
+			expired = append(expired, hash)
+		}
+
+		return true
+	})
+
+	for _, hash := range expired {
+		s.removeExpired(hash)
+	}
+}
+
+func (s *Store) removeExpired(hash string) {
+	s.storage().Delete(hash)
+	s.Expiry.ClearExpiry(hash)
+
+	if s.handlePool != nil {
+		s.handlePool.evict(hash)
+	}
+
+	if s.Inventory != nil {
+		if forgetter, ok := s.Inventory.(Forgetter); ok {
+			forgetter.Forget(hash)
+		}
+	}
+
+	if s.Cache != nil {
+		if evictor, ok := s.Cache.(Evictor); ok {
+			evictor.Evict(hash)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package stor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileInventoryFlushEvery is the number of appended log lines buffered
+// between fsyncs, amortizing the fsync cost across a batch of writes
+const fileInventoryFlushEvery = 32
+
+// fileInventoryCompactEvery is the number of appended log lines between
+// compactions, keeping replay on NewFileInventory bounded
+const fileInventoryCompactEvery = 10000
+
+// fileInventoryEntry is the size and insertion time recorded for a
+// single hash, as passed to SeeSized
+type fileInventoryEntry struct {
+	size   int64
+	seenAt time.Time
+}
+
+// FileInventory is a durable Inventory backed by an append-only log: one
+// line per insertion, "hash size unixSeconds". On construction the log
+// is replayed to rebuild the in-memory set, so a FileInventory survives
+// a process restart. The log is periodically rewritten as a compacted,
+// deduplicated snapshot so replay time doesn't grow unbounded, and
+// buffered writes are fsync'd in batches rather than on every insertion.
+// Compaction preserves each hash's original size and insertion time
+// rather than rewriting them as a fresh snapshot timestamp.
+type FileInventory struct {
+	m     map[string]fileInventoryEntry
+	mlock sync.RWMutex
+
+	path string
+	fh   *os.File
+	w    *bufio.Writer
+
+	logMutex     sync.Mutex
+	sinceFlush   int
+	sinceCompact int
+}
+
+func NewFileInventory(path string) (*FileInventory, error) {
+	fi := &FileInventory{m: make(map[string]fileInventoryEntry), path: path}
+	if err := fi.load(); err != nil {
+		return nil, err
+	}
+
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi.fh = fh
+	fi.w = bufio.NewWriter(fh)
+	return fi, nil
+}
+
+// load replays the log into the in-memory set. A missing log is treated
+// as an empty inventory rather than an error, since it means this is a
+// fresh store.
+func (fi *FileInventory) load() error {
+	fh, err := os.Open(fi.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := fileInventoryEntry{}
+		if len(fields) >= 2 {
+			if size, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				entry.size = size
+			}
+		}
+
+		if len(fields) >= 3 {
+			if sec, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				entry.seenAt = time.Unix(sec, 0)
+			}
+		}
+
+		fi.m[fields[0]] = entry
+	}
+
+	return scanner.Err()
+}
+
+func (fi *FileInventory) See(hash string) {
+	fi.SeeSized(hash, 0, time.Now())
+}
+
+// SeeSized records an insertion, appending it to the log if it's not
+// already known
+func (fi *FileInventory) SeeSized(hash string, size int64, seenAt time.Time) {
+	fi.mlock.Lock()
+	_, exists := fi.m[hash]
+	fi.m[hash] = fileInventoryEntry{size: size, seenAt: seenAt}
+	fi.mlock.Unlock()
+
+	if exists {
+		return
+	}
+
+	fi.logMutex.Lock()
+	defer fi.logMutex.Unlock()
+
+	fmt.Fprintf(fi.w, "%s %d %d\n", hash, size, seenAt.Unix())
+	fi.sinceFlush++
+	fi.sinceCompact++
+
+	if fi.sinceFlush >= fileInventoryFlushEvery {
+		fi.flushLocked()
+	}
+
+	if fi.sinceCompact >= fileInventoryCompactEvery {
+		fi.compactLocked()
+	}
+}
+
+// Forget removes hash from the inventory and rewrites the log so it
+// isn't replayed back in on the next restart.
+func (fi *FileInventory) Forget(hash string) {
+	fi.mlock.Lock()
+	_, exists := fi.m[hash]
+	delete(fi.m, hash)
+	fi.mlock.Unlock()
+
+	if !exists {
+		return
+	}
+
+	fi.logMutex.Lock()
+	defer fi.logMutex.Unlock()
+	fi.compactLocked()
+}
+
+func (fi *FileInventory) Has(hash string) bool {
+	fi.mlock.RLock()
+	defer fi.mlock.RUnlock()
+
+	_, exists := fi.m[hash]
+	return exists
+}
+
+// Close flushes and closes the underlying log file
+func (fi *FileInventory) Close() error {
+	fi.logMutex.Lock()
+	defer fi.logMutex.Unlock()
+
+	if err := fi.flushLocked(); err != nil {
+		return err
+	}
+
+	return fi.fh.Close()
+}
+
+// Flush fsyncs any log writes buffered since the last flush
+func (fi *FileInventory) Flush() error {
+	fi.logMutex.Lock()
+	defer fi.logMutex.Unlock()
+	return fi.flushLocked()
+}
+
+func (fi *FileInventory) flushLocked() error {
+	if err := fi.w.Flush(); err != nil {
+		return err
+	}
+
+	if err := fi.fh.Sync(); err != nil {
+		return err
+	}
+
+	fi.sinceFlush = 0
+	return nil
+}
+
+// compactLocked rewrites the log as a deduplicated snapshot of the
+// current in-memory set. logMutex must be held.
+func (fi *FileInventory) compactLocked() error {
+	if err := fi.flushLocked(); err != nil {
+		return err
+	}
+
+	fi.mlock.RLock()
+	entries := make(map[string]fileInventoryEntry, len(fi.m))
+	for hash, entry := range fi.m {
+		entries[hash] = entry
+	}
+	fi.mlock.RUnlock()
+
+	tmpPath := fi.path + ".compact"
+	tmpFh, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmpFh)
+	for hash, entry := range entries {
+		fmt.Fprintf(w, "%s %d %d\n", hash, entry.size, entry.seenAt.Unix())
+	}
+
+	if err := w.Flush(); err != nil {
+		tmpFh.Close()
+		return err
+	}
+
+	if err := tmpFh.Sync(); err != nil {
+		tmpFh.Close()
+		return err
+	}
+
+	if err := tmpFh.Close(); err != nil {
+		return err
+	}
+
+	if err := fi.fh.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, fi.path); err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(fi.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi.fh = fh
+	fi.w = bufio.NewWriter(fh)
+	fi.sinceFlush = 0
+	fi.sinceCompact = 0
+	return nil
+}
@@ -0,0 +1,150 @@
+package stor
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fsHashDir returns the directory an element is stored under: a
+// subdirectory of path keyed by the first two characters of hash, to
+// avoid a single huge flat directory
+func fsHashDir(path, hash string) string {
+	var subdir string
+	if len(hash) < 2 {
+		subdir = hash
+	} else {
+		subdir = hash[:2]
+	}
+
+	return filepath.Join(path, subdir)
+}
+
+// FSStorage is the original directory-per-prefix layout: every element
+// is a file named after its hash, under a subdirectory keyed by the
+// first two characters of the hash. It's the default Storage for a
+// Store that doesn't set one explicitly.
+type FSStorage struct {
+	Path string
+}
+
+func NewFSStorage(path string) *FSStorage {
+	return &FSStorage{Path: path}
+}
+
+// Put writes r to a temp file in the same directory as the final
+// element, fsyncs it, and atomically renames it into place, so a crash
+// mid-write never leaves a truncated file at the final path. The parent
+// directory is fsync'd too, best-effort, so the rename itself survives
+// a crash on filesystems that need it.
+func (f *FSStorage) Put(hash string, r io.Reader) error {
+	dir := fsHashDir(f.Path, hash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, hash+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, hash)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir fsyncs a directory so a preceding rename within it is
+// durable. Best-effort: not every platform/filesystem supports fsync on
+// a directory, so a failure here is not treated as a Put failure.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	defer d.Close()
+	d.Sync()
+}
+
+func (f *FSStorage) Get(hash string) (io.ReadCloser, error) {
+	fh, err := os.Open(filepath.Join(fsHashDir(f.Path, hash), hash))
+	if err != nil {
+		return nil, ErrDoesNotExist
+	}
+
+	return fh, nil
+}
+
+func (f *FSStorage) Delete(hash string) error {
+	return os.Remove(filepath.Join(fsHashDir(f.Path, hash), hash))
+}
+
+func (f *FSStorage) Iterate(fn func(hash string) bool) error {
+	subdirs, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(f.Path, subdir.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			name := file.Name()
+			if strings.Contains(name, ".tmp-") {
+				// a Put that crashed mid-write, left behind by
+				// ioutil.TempFile(dir, hash+".tmp-*")
+				continue
+			}
+
+			if strings.HasSuffix(name, ".expires") {
+				// a FileExpiry sidecar kept alongside the element
+				continue
+			}
+
+			if !fn(name) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package stor
+
+// Load reconciles s.Inventory against the elements actually present in
+// storage, adding any hash found on disk but missing from the
+// inventory. This covers the crash window between a successful put and
+// the inventory recording it, and should be called once at startup
+// before the Store is used. It's a no-op unless Storage is an
+// FSStorage; other backends are assumed to be reconciled by Iterate
+// returning only what's really there, which Inventory doesn't consult.
+func (s *Store) Load() error {
+	if s.Inventory == nil {
+		return nil
+	}
+
+	fs, ok := s.storage().(*FSStorage)
+	if !ok {
+		return nil
+	}
+
+	return fs.Iterate(func(hash string) bool {
+		if !s.Inventory.Has(hash) {
+			s.Inventory.See(hash)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,71 @@
+package stor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// MemStorage is an in-memory Storage. It's mainly useful in tests, in
+// place of a Store that writes real files to disk.
+type MemStorage struct {
+	m     map[string][]byte
+	mutex sync.RWMutex
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{m: make(map[string][]byte)}
+}
+
+func (m *MemStorage) Put(hash string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.m[hash] = data
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *MemStorage) Get(hash string) (io.ReadCloser, error) {
+	m.mutex.RLock()
+	data, exists := m.m[hash]
+	m.mutex.RUnlock()
+	if !exists {
+		return nil, ErrDoesNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemStorage) Delete(hash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.m[hash]; !exists {
+		return ErrDoesNotExist
+	}
+
+	delete(m.m, hash)
+	return nil
+}
+
+func (m *MemStorage) Iterate(fn func(hash string) bool) error {
+	m.mutex.RLock()
+	hashes := make([]string, 0, len(m.m))
+	for hash := range m.m {
+		hashes = append(hashes, hash)
+	}
+	m.mutex.RUnlock()
+
+	for _, hash := range hashes {
+		if !fn(hash) {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package stor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrRangeUnsupported is returned by GetRange/OpenReader when the
+	// Store isn't backed by an FSStorage, since ranged/seekable reads
+	// need a real, seekable file on disk
+	ErrRangeUnsupported = errors.New("ranged reads require a file system-backed store")
+)
+
+// A RangeCache caches sub-ranges of a hash's data, letting a Store serve
+// GetRange without reading the whole value back from disk. It's the
+// range-oriented counterpart to RetrievalCache/InsertionCache. Store.GetRange
+// uses it when s.Cache implements it; GetRange never calls Cache.Get.
+type RangeCache interface {
+	// GetRange returns the cached bytes for [offset,offset+length) if the
+	// entire range is resident, and false otherwise
+	GetRange(hash string, offset, length int64) (data []byte, ok bool)
+	// SeeRange records that [offset,offset+len(data)) of hash is resident
+	SeeRange(hash string, offset int64, data []byte)
+}
+
+// pooledHandle is a single open *os.File shared between ranged reads of
+// the same hash, so repeated GetRange calls don't reopen the file
+type pooledHandle struct {
+	mutex sync.Mutex
+	fh    *os.File
+}
+
+// handlePool keeps one pooledHandle per hash for ranged reads
+type handlePool struct {
+	mutex   sync.Mutex
+	handles map[string]*pooledHandle
+}
+
+func (p *handlePool) get(fsPath, hash string) (*pooledHandle, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.handles == nil {
+		p.handles = make(map[string]*pooledHandle)
+	}
+
+	if h, exists := p.handles[hash]; exists {
+		return h, nil
+	}
+
+	fn := filepath.Join(fsHashDir(fsPath, hash), hash)
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, ErrDoesNotExist
+	}
+
+	h := &pooledHandle{fh: fh}
+	p.handles[hash] = h
+	return h, nil
+}
+
+func (p *handlePool) closeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for hash, h := range p.handles {
+		h.fh.Close()
+		delete(p.handles, hash)
+	}
+}
+
+// evict closes and drops the pooled handle for hash, if any, so a
+// subsequent GetRange reopens the file rather than reading through a
+// handle to an unlinked or stale file
+func (p *handlePool) evict(hash string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if h, exists := p.handles[hash]; exists {
+		h.fh.Close()
+		delete(p.handles, hash)
+	}
+}
+
+// OpenReader opens a fresh, caller-owned reader for an element in the
+// store. Unlike GetRange, it does not share a pooled file handle: the
+// returned io.ReadSeekCloser is closed by the caller when done
+func (s *Store) OpenReader(hash string) (io.ReadSeekCloser, error) {
+	if len(hash) == 0 || strings.Contains(hash, string(filepath.Separator)) {
+		return nil, ErrInvalidHash
+	}
+
+	if s.Expiry != nil {
+		if expiresAt, ok := s.Expiry.ExpiresAt(hash); ok && !time.Now().Before(expiresAt) {
+			return nil, ErrDoesNotExist
+		}
+	}
+
+	if s.Inventory != nil && !s.Inventory.Has(hash) {
+		return nil, ErrDoesNotExist
+	}
+
+	fs, ok := s.storage().(*FSStorage)
+	if !ok {
+		return nil, ErrRangeUnsupported
+	}
+
+	fh, err := os.Open(filepath.Join(fsHashDir(fs.Path, hash), hash))
+	if err != nil {
+		return nil, ErrDoesNotExist
+	}
+
+	return fh, nil
+}
+
+// GetRange reads [offset,offset+length) of an element without reading
+// the whole value into memory. A RangeCache in s.Cache, if any, is
+// consulted first and fed the result on a miss
+func (s *Store) GetRange(hash string, offset, length int64) ([]byte, error) {
+	if len(hash) == 0 || strings.Contains(hash, string(filepath.Separator)) {
+		return nil, ErrInvalidHash
+	}
+
+	if offset < 0 || length < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	if s.Expiry != nil {
+		if expiresAt, ok := s.Expiry.ExpiresAt(hash); ok && !time.Now().Before(expiresAt) {
+			return nil, ErrDoesNotExist
+		}
+	}
+
+	var rangeCache RangeCache
+	if s.Cache != nil {
+		if rc, ok := s.Cache.(RangeCache); ok {
+			rangeCache = rc
+			if data, ok := rc.GetRange(hash, offset, length); ok {
+				return data, nil
+			}
+		}
+	}
+
+	if s.Inventory != nil && !s.Inventory.Has(hash) {
+		return nil, ErrDoesNotExist
+	}
+
+	fs, ok := s.storage().(*FSStorage)
+	if !ok {
+		return nil, ErrRangeUnsupported
+	}
+
+	s.handlePoolOnce.Do(func() {
+		s.handlePool = &handlePool{}
+	})
+
+	h, err := s.handlePool.get(fs.Path, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, err := h.fh.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	n, err := io.ReadFull(h.fh, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	data = data[:n]
+	if rangeCache != nil {
+		rangeCache.SeeRange(hash, offset, data)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,102 @@
+//go:build s3
+
+// Package stor's S3Storage depends on the aws-sdk-go-v2 modules, which
+// aren't vendored into this tree. It's gated behind the s3 build tag so
+// the rest of the package builds without that dependency; pass -tags s3
+// to include it once a go.mod pulling in aws-sdk-go-v2 is present.
+package stor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Storage stores elements as objects in a single S3 bucket, keyed by
+// hash under an optional Prefix. It's meant for deployments where the
+// store's data should live off the host's local disk; GetRange and
+// OpenReader aren't usable against it, since those need a seekable file
+// (see ErrRangeUnsupported).
+type S3Storage struct {
+	Client *s3.Client
+	Bucket string
+	// Prefix is prepended to every object key, e.g. "stor/"
+	Prefix string
+}
+
+func NewS3Storage(client *s3.Client, bucket, prefix string) *S3Storage {
+	return &S3Storage{Client: client, Bucket: bucket, Prefix: prefix}
+}
+
+func (st *S3Storage) key(hash string) string {
+	return st.Prefix + hash
+}
+
+func (st *S3Storage) Put(hash string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(st.key(hash)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (st *S3Storage) Get(hash string) (io.ReadCloser, error) {
+	out, err := st.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(st.key(hash)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrDoesNotExist
+		}
+
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (st *S3Storage) Delete(hash string) error {
+	_, err := st.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(st.Bucket),
+		Key:    aws.String(st.key(hash)),
+	})
+	return err
+}
+
+func (st *S3Storage) Iterate(fn func(hash string) bool) error {
+	paginator := s3.NewListObjectsV2Paginator(st.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(st.Bucket),
+		Prefix: aws.String(st.Prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range page.Contents {
+			hash := strings.TrimPrefix(aws.ToString(obj.Key), st.Prefix)
+			if !fn(hash) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package stor
+
+import (
+	"sync"
+)
+
+// a sparseSpan is one contiguous, resident byte range of a cached value:
+// [start,end) with the corresponding data
+type sparseSpan struct {
+	start, end int64
+	data       []byte
+}
+
+// a sparseEntry is the set of resident spans cached for a single hash,
+// kept sorted by start and non-overlapping via merging on insert
+type sparseEntry struct {
+	spans []sparseSpan
+}
+
+// insert records [start,start+len(data)) as resident, merging it with
+// any overlapping or adjacent spans
+func (e *sparseEntry) insert(start int64, data []byte) {
+	span := sparseSpan{start: start, end: start + int64(len(data)), data: data}
+
+	merged := make([]sparseSpan, 0, len(e.spans)+1)
+	i := 0
+	for i < len(e.spans) && e.spans[i].end < span.start {
+		merged = append(merged, e.spans[i])
+		i++
+	}
+
+	for i < len(e.spans) && e.spans[i].start <= span.end {
+		s := e.spans[i]
+		if s.start < span.start {
+			prefix := span.start - s.start
+			span.data = append(append([]byte{}, s.data[:prefix]...), span.data...)
+			span.start = s.start
+		}
+
+		if s.end > span.end {
+			suffix := span.end - s.start
+			span.data = append(span.data, s.data[suffix:]...)
+			span.end = s.end
+		}
+
+		i++
+	}
+
+	merged = append(merged, span)
+	e.spans = append(merged, e.spans[i:]...)
+}
+
+// query returns the cached bytes for [start,end) if a single resident
+// span fully covers it
+func (e *sparseEntry) query(start, end int64) ([]byte, bool) {
+	for _, s := range e.spans {
+		if s.start <= start && end <= s.end {
+			return s.data[start-s.start : end-s.start], true
+		}
+	}
+
+	return nil, false
+}
+
+// SparseCache records, per hash, which byte ranges have been seen and
+// serves GetRange hits without requiring the whole value to be cached.
+// It's meant for large blobs where a full ioutil.ReadAll-style Cache is
+// too expensive; Get always misses since a SparseCache doesn't track
+// whether a value's full length is resident, only GetRange is served
+// from it. SeePut/SeeGet record the given data as the [0,len(data))
+// range, so whole-value Store traffic still warms the range cache.
+type SparseCache struct {
+	m     map[string]*sparseEntry
+	mutex sync.RWMutex
+}
+
+func NewSparseCache() *SparseCache {
+	return &SparseCache{m: make(map[string]*sparseEntry)}
+}
+
+// Get always returns nil; see the SparseCache doc comment
+func (c *SparseCache) Get(hash string) (data []byte) {
+	return nil
+}
+
+func (c *SparseCache) SeePut(hash string, data []byte) {
+	c.SeeRange(hash, 0, data)
+}
+
+func (c *SparseCache) SeeGet(hash string, data []byte) {
+	c.SeeRange(hash, 0, data)
+}
+
+// Evict drops every resident span cached for hash
+func (c *SparseCache) Evict(hash string) {
+	c.mutex.Lock()
+	delete(c.m, hash)
+	c.mutex.Unlock()
+}
+
+// SeeRange records [offset,offset+len(data)) of hash as resident
+func (c *SparseCache) SeeRange(hash string, offset int64, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, exists := c.m[hash]
+	if !exists {
+		e = &sparseEntry{}
+		c.m[hash] = e
+	}
+
+	e.insert(offset, data)
+}
+
+// GetRange returns the cached bytes for [offset,offset+length) if the
+// entire range is resident
+func (c *SparseCache) GetRange(hash string, offset, length int64) (data []byte, ok bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	e, exists := c.m[hash]
+	if !exists {
+		return nil, false
+	}
+
+	return e.query(offset, offset+length)
+}
@@ -7,13 +7,17 @@ package stor
 */
 
 import (
+	"bytes"
 	"container/list"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -23,6 +27,21 @@ var (
 	ErrAlreadyExist  = errors.New("element already exist in store")
 )
 
+// writeErrors aggregates the asynchronous write failures observed by a
+// single Sync call
+type writeErrors struct {
+	errs []error
+}
+
+func (e *writeErrors) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
 type Cache interface {
 	// gets called when an element is retrieved from a store
 	// nil is returned if the element does not exist in cache
@@ -71,6 +90,12 @@ func (c *CacheAll) Get(hash string) (data []byte) {
 	}
 }
 
+func (c *CacheAll) Evict(hash string) {
+	c.mlock.Lock()
+	delete(c.m, hash)
+	c.mlock.Unlock()
+}
+
 type cacheElem struct {
 	hash string
 	data []byte
@@ -139,6 +164,15 @@ func (c *CacheMostRecent) Get(hash string) (data []byte) {
 	return nil
 }
 
+func (c *CacheMostRecent) Evict(hash string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if listEl, exists := c.m[hash]; exists {
+		c.l.Remove(listEl)
+		delete(c.m, hash)
+	}
+}
+
 // A Limiter can deny an element to be inserted into the Store
 type Limiter interface {
 	// returns true if the element is not to be inserted
@@ -153,6 +187,31 @@ type Inventory interface {
 	Has(hash string) bool
 }
 
+// A SizedInventory additionally wants to know the size and time of an
+// insertion, e.g. to persist it. If Store.Inventory implements this,
+// Put calls SeeSized instead of See; Load still calls See.
+type SizedInventory interface {
+	Inventory
+	SeeSized(hash string, size int64, seenAt time.Time)
+}
+
+// A Flusher is given a chance to persist any buffered state on Sync.
+type Flusher interface {
+	Flush() error
+}
+
+// A Forgetter can drop a single hash from an Inventory, e.g. once the
+// Store's janitor has expired and removed it
+type Forgetter interface {
+	Forget(hash string)
+}
+
+// An Evictor can drop a single hash from a Cache, e.g. once the Store's
+// janitor has expired and removed it
+type Evictor interface {
+	Evict(hash string)
+}
+
 type DefaultInventory struct {
 	m     map[string]struct{}
 	mlock sync.RWMutex
@@ -176,17 +235,38 @@ func (i *DefaultInventory) Has(hash string) bool {
 	return exists
 }
 
+func (i *DefaultInventory) Forget(hash string) {
+	i.mlock.Lock()
+	delete(i.m, hash)
+	i.mlock.Unlock()
+}
+
 type Store struct {
 	Limiter   Limiter
 	Cache     Cache
 	Inventory Inventory
+	// Expiry tracks TTLs set via PutWithTTL. If unset, PutWithTTL behaves
+	// like Put and elements never expire.
+	Expiry Expiry
+	// Storage is the persistence backend. If not set, it defaults to an
+	// FSStorage rooted at Path
+	Storage Storage
 	// Absolute or relative path in file system to store.
 	// If not set,the current directory is used.
-	// Be aware that Remove removes this path
+	// Be aware that Remove removes this path if Storage is unset, and
+	// an FSStorage's own Path if Storage is an FSStorage
 	Path string
 
-	// writeErr is set on asyncronous write error
-	writeErr     error
+	// OnWriteError, if set, is called whenever an asynchronous write
+	// started by Put fails
+	OnWriteError func(hash string, err error)
+
+	// writeErrs collects asynchronous write errors keyed by hash, so a
+	// failed write doesn't have to race with the caller's next Put to
+	// be observed
+	writeErrs     map[string]error
+	writeErrsLock sync.RWMutex
+
 	activeWrites sync.WaitGroup
 
 	// While an element is being written to disk, it may be retrieved
@@ -194,34 +274,32 @@ type Store struct {
 	// hopefully small cache.
 	inTransfer     map[string][]byte
 	inTransferLock sync.RWMutex
-}
 
-func (s *Store) hashDir(hash string) string {
-	var subdir string
-	if len(hash) < 2 {
-		subdir = hash
-	} else {
-		subdir = hash[:2]
-	}
+	// handlePool holds open file handles reused across GetRange calls
+	handlePool *handlePool
+	// handlePoolOnce guards the lazy handlePool assignment in GetRange,
+	// since concurrent GetRanges may race to initialize it otherwise
+	handlePoolOnce sync.Once
 
-	return filepath.Join(s.Path, subdir)
+	// storageOnce guards the lazy default-Storage assignment in storage,
+	// since concurrent Puts may race to initialize it otherwise
+	storageOnce sync.Once
 }
 
-func (s *Store) put(hash string, data []byte) error {
-	dir := s.hashDir(hash)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
+// storage returns s.Storage, defaulting it to an FSStorage rooted at
+// s.Path the first time it's needed
+func (s *Store) storage() Storage {
+	s.storageOnce.Do(func() {
+		if s.Storage == nil {
+			s.Storage = NewFSStorage(s.Path)
+		}
+	})
 
-	fn := filepath.Join(dir, hash)
-	fh, err := os.Create(fn)
-	if err != nil {
-		return err
-	}
+	return s.Storage
+}
 
-	defer fh.Close()
-	_, err = fh.Write(data)
-	return err
+func (s *Store) put(hash string, data []byte) error {
+	return s.storage().Put(hash, bytes.NewReader(data))
 }
 
 // Put an Element into a store. hash must be unique
@@ -231,10 +309,6 @@ func (s *Store) Put(hash string, data []byte) error {
 		return ErrInvalidHash
 	}
 
-	if s.writeErr != nil {
-		return s.writeErr
-	}
-
 	if s.Limiter != nil && s.Limiter.Deny(hash, data) {
 		return ErrLimiterDenied
 	}
@@ -268,10 +342,16 @@ func (s *Store) Put(hash string, data []byte) error {
 		}()
 
 		if err := s.put(hash, data); err != nil {
-			s.writeErr = err
+			s.setWriteErr(hash, err)
 		} else {
+			s.clearWriteErr(hash)
+
 			if s.Inventory != nil {
-				s.Inventory.See(hash)
+				if si, ok := s.Inventory.(SizedInventory); ok {
+					si.SeeSized(hash, int64(len(data)), time.Now())
+				} else {
+					s.Inventory.See(hash)
+				}
 			}
 		}
 	}()
@@ -279,15 +359,46 @@ func (s *Store) Put(hash string, data []byte) error {
 	return nil
 }
 
+// setWriteErr records an asynchronous write failure for hash and, if
+// set, notifies OnWriteError
+func (s *Store) setWriteErr(hash string, err error) {
+	s.writeErrsLock.Lock()
+	if s.writeErrs == nil {
+		s.writeErrs = make(map[string]error)
+	}
+
+	s.writeErrs[hash] = err
+	s.writeErrsLock.Unlock()
+
+	if s.OnWriteError != nil {
+		s.OnWriteError(hash, err)
+	}
+}
+
+// clearWriteErr drops any recorded write failure for hash, so a retried
+// Put that succeeds doesn't leave a stale error behind
+func (s *Store) clearWriteErr(hash string) {
+	s.writeErrsLock.Lock()
+	delete(s.writeErrs, hash)
+	s.writeErrsLock.Unlock()
+}
+
+// PutErr returns the error from the last failed asynchronous write of
+// hash, or nil if its last write succeeded or hasn't been observed yet
+func (s *Store) PutErr(hash string) error {
+	s.writeErrsLock.RLock()
+	defer s.writeErrsLock.RUnlock()
+	return s.writeErrs[hash]
+}
+
 func (s *Store) get(hash string) ([]byte, error) {
-	fn := filepath.Join(s.hashDir(hash), hash)
-	fh, err := os.Open(fn)
+	rc, err := s.storage().Get(hash)
 	if err != nil {
-		return nil, ErrDoesNotExist
+		return nil, err
 	}
 
-	defer fh.Close()
-	return ioutil.ReadAll(fh)
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
 // Get an element from a store by it's unique hashentifier
@@ -296,6 +407,12 @@ func (s *Store) Get(hash string) ([]byte, error) {
 		return nil, ErrInvalidHash
 	}
 
+	if s.Expiry != nil {
+		if expiresAt, ok := s.Expiry.ExpiresAt(hash); ok && !time.Now().Before(expiresAt) {
+			return nil, ErrDoesNotExist
+		}
+	}
+
 	// main cache lookup before transfer cache lookup and inventory check
 	if s.Cache != nil {
 		if b := s.Cache.Get(hash); b != nil {
@@ -328,13 +445,59 @@ func (s *Store) Get(hash string) ([]byte, error) {
 	return data, err
 }
 
-// Wait for all writes to be completed
-func (s *Store) Sync() {
+// Sync waits for all writes to be completed, gives the Inventory a
+// chance to persist any buffered state, and returns an aggregated error
+// for every hash whose most recent write failed. Reading an error via
+// PutErr does not clear it.
+func (s *Store) Sync() error {
 	s.activeWrites.Wait()
+
+	var errs []error
+	if f, ok := s.Inventory.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.writeErrsLock.RLock()
+	for hash, err := range s.writeErrs {
+		errs = append(errs, fmt.Errorf("%s: %w", hash, err))
+	}
+	s.writeErrsLock.RUnlock()
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &writeErrors{errs: errs}
 }
 
-// Remove the store from the fle system
+// Remove the store. If Storage is unset or is an FSStorage, this removes
+// the FSStorage's Path (s.Path when Storage is unset) from the file
+// system; otherwise every element is deleted
+// individually via Storage.Iterate/Delete
 func (s *Store) Remove() error {
 	s.Sync()
-	return os.RemoveAll(s.Path)
+	if s.handlePool != nil {
+		s.handlePool.closeAll()
+	}
+
+	if closer, ok := s.Inventory.(io.Closer); ok {
+		closer.Close()
+	}
+
+	if fs, ok := s.storage().(*FSStorage); ok {
+		return os.RemoveAll(fs.Path)
+	}
+
+	var firstErr error
+	s.storage().Iterate(func(hash string) bool {
+		if err := s.storage().Delete(hash); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		return true
+	})
+
+	return firstErr
 }
@@ -2,6 +2,9 @@ package stor
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 )
@@ -31,6 +34,23 @@ func TestPutGet(t *testing.T) {
 	}
 }
 
+func TestPutGetMemStorage(t *testing.T) {
+	s := Store{Storage: NewMemStorage()}
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	// force past the transfer cache and read back through MemStorage
+	if retrieved, err := s.get("foo"); err != nil {
+		t.Fatal(err)
+	} else if bytes.Compare(testData, retrieved) != 0 {
+		t.Fatal("expected", testData, "was", retrieved)
+	}
+}
+
 func TestInventory(t *testing.T) {
 	s := Store{Path: "foo", Inventory: NewDefaultInventory()}
 	defer s.Remove()
@@ -50,6 +70,176 @@ func TestInventory(t *testing.T) {
 	}
 }
 
+func TestPutErr(t *testing.T) {
+	if err := os.MkdirAll("foo", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("foo")
+
+	// "hash1" hashes to prefix dir "foo/ha"; putting a regular file
+	// there makes the prefix directory impossible to create
+	if err := ioutil.WriteFile("foo/ha", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotHash string
+	var gotErr error
+	s := Store{Path: "foo", OnWriteError: func(hash string, err error) {
+		gotHash = hash
+		gotErr = err
+	}}
+
+	if err := s.Put("hash1", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Sync(); err == nil {
+		t.Fatal("expected Sync to return an aggregated error")
+	}
+
+	if s.PutErr("hash1") == nil {
+		t.Fatal("expected PutErr to report the failed write")
+	}
+
+	if gotHash != "hash1" || gotErr == nil {
+		t.Fatal("expected OnWriteError to be called with the failed hash")
+	}
+}
+
+func TestFileInventory(t *testing.T) {
+	if err := os.MkdirAll("foo", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("foo")
+
+	path := "foo/inventory.log"
+	fi, err := NewFileInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi.SeeSized("abc", 4, time.Now())
+	if !fi.Has("abc") {
+		t.Fatal("expected abc to be known")
+	}
+
+	if err := fi.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a restart: reopen the log and check it replays
+	fi2, err := NewFileInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi2.Has("abc") {
+		t.Fatal("expected abc to survive a restart")
+	}
+}
+
+func TestFileInventoryCompactionPreservesSizeAndTime(t *testing.T) {
+	if err := os.MkdirAll("foo", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("foo")
+
+	path := "foo/inventory.log"
+	fi, err := NewFileInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seenAt := time.Unix(1700000000, 0)
+	fi.SeeSized("abc", 12345, seenAt)
+
+	fi.logMutex.Lock()
+	err = fi.compactLocked()
+	fi.logMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a restart: reopen the compacted log and check it replayed
+	// the original size and time, not the compaction time
+	fi2, err := NewFileInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := fi2.m["abc"]
+	if !ok {
+		t.Fatal("expected abc to survive compaction")
+	}
+
+	if entry.size != 12345 {
+		t.Fatal("expected size 12345 to survive compaction, was", entry.size)
+	}
+
+	if !entry.seenAt.Equal(seenAt) {
+		t.Fatal("expected seenAt", seenAt, "to survive compaction, was", entry.seenAt)
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	s := Store{Path: "foo"}
+	defer s.Remove()
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	// a fresh Store, as if after a crash: its inventory doesn't know
+	// about the file that's already on disk until Load reconciles it
+	fresh := Store{Path: "foo", Inventory: NewDefaultInventory()}
+	if fresh.Inventory.Has("foo") {
+		t.Fatal("expected a fresh inventory to not yet know about foo")
+	}
+
+	if err := fresh.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fresh.Inventory.Has("foo") {
+		t.Fatal("expected Load to reconcile foo from disk")
+	}
+}
+
+func TestStoreLoadSkipsStrayTempFiles(t *testing.T) {
+	s := Store{Path: "foo"}
+	defer s.Remove()
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	// simulate a crash mid-Put: a temp file left behind in the hash dir
+	dir := fsHashDir("foo", "foo")
+	if err := ioutil.WriteFile(dir+"/foo.tmp-deadbeef", testData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := Store{Path: "foo", Inventory: NewDefaultInventory()}
+	if err := fresh.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fresh.Inventory.Has("foo.tmp-deadbeef") {
+		t.Fatal("expected Load to skip a stray temp file")
+	}
+
+	if !fresh.Inventory.Has("foo") {
+		t.Fatal("expected Load to still reconcile the real file")
+	}
+}
+
 func testCacheInsertion(t *testing.T, c Cache) {
 	s := Store{Path: "foo", Cache: c}
 	defer s.Remove()
@@ -80,6 +270,11 @@ func TestCacheAllInsertion(t *testing.T) {
 	testCacheInsertion(t, c)
 }
 
+func TestCacheLFUInsertion(t *testing.T) {
+	c := NewCacheLFU(1024)
+	testCacheInsertion(t, c)
+}
+
 func testCacheOverwrite(t *testing.T, c Cache) {
 	s := Store{Path: "foo", Cache: c}
 	defer s.Remove()
@@ -111,6 +306,80 @@ func TestCacheAllOverwrite(t *testing.T) {
 	testCacheOverwrite(t, c)
 }
 
+func TestCacheLFUOverwrite(t *testing.T) {
+	c := NewCacheLFU(1024)
+	testCacheOverwrite(t, c)
+}
+
+func TestCacheChainInsertion(t *testing.T) {
+	c := NewCacheChain(NewCacheMostRecent(1), NewCacheLFU(1024))
+	testCacheInsertion(t, c)
+}
+
+func TestCacheChainOverwrite(t *testing.T) {
+	c := NewCacheChain(NewCacheMostRecent(1), NewCacheLFU(1024))
+	testCacheOverwrite(t, c)
+}
+
+func TestCacheChainPromotion(t *testing.T) {
+	front := NewCacheMostRecent(1)
+	back := NewCacheLFU(1024)
+	c := NewCacheChain(front, back)
+	s := Store{Path: "foo", Cache: c}
+	defer s.Remove()
+
+	s.Put("foo", testData)
+	s.Sync()
+
+	// not yet in front: SeePut only reached the back LFU layer above,
+	// since the front CacheMostRecent inserts on SeeGet, not SeePut
+	if front.Get("foo") != nil {
+		t.Fatal("expected foo to not yet be promoted to the front layer")
+	}
+
+	// Get should fault the value in from the back layer and promote it
+	if data, err := s.Get("foo"); err != nil || bytes.Compare(data, testData) != 0 {
+		t.Fatal("expected", testData, "was", data)
+	}
+
+	if front.Get("foo") == nil {
+		t.Fatal("expected foo to be promoted to the front layer")
+	}
+}
+
+func TestCacheLFUEviction(t *testing.T) {
+	maxBytes := int64(len(testData) + len(testData2))
+	c := NewCacheLFU(maxBytes)
+	s := Store{Path: "foo", Cache: c}
+	defer s.Remove()
+
+	s.Put("foo", testData)
+	s.Put("bar", testData2)
+	s.Sync()
+
+	// "foo" is read repeatedly, bumping its frequency above "bar"'s,
+	// which is never read back after insertion
+	s.Get("foo")
+	s.Get("foo")
+
+	// force an eviction by inserting a third entry that doesn't fit
+	// alongside the other two
+	c.SeePut("baz", testData)
+
+	if c.Bytes() > maxBytes {
+		t.Fatal("cache exceeds byte budget:", c.Bytes())
+	}
+
+	// "bar" is the least frequently used entry and should have been evicted
+	if c.Get("bar") != nil {
+		t.Fatal("expected bar to be evicted")
+	}
+
+	if c.Get("foo") == nil {
+		t.Fatal("expected foo to survive eviction")
+	}
+}
+
 func TestCacheMostRecent(t *testing.T) {
 	// logs retrieval times
 	// go test -v -run 'CacheMostRecent$'
@@ -170,6 +439,190 @@ func TestCacheMostRecentExpiry(t *testing.T) {
 	}
 }
 
+func TestGetRange(t *testing.T) {
+	s := Store{Path: "foo"}
+	defer s.Remove()
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	data, err := s.GetRange("foo", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(data, testData[1:3]) != 0 {
+		t.Fatal("expected", testData[1:3], "was", data)
+	}
+}
+
+func TestGetRangeSparseCache(t *testing.T) {
+	s := Store{Path: "foo"}
+	defer s.Remove()
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	// a second Store, with a fresh SparseCache, reading from the same path
+	c := NewSparseCache()
+	cached := Store{Path: "foo", Cache: c}
+
+	// miss: read the sub-range from disk and warm the sparse cache
+	if _, err := cached.GetRange("foo", 0, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, ok := c.GetRange("foo", 0, 2); !ok || bytes.Compare(data, testData[:2]) != 0 {
+		t.Fatal("expected range to be served from the sparse cache")
+	}
+}
+
+func TestOpenReader(t *testing.T) {
+	s := Store{Path: "foo"}
+	defer s.Remove()
+
+	if err := s.Put("foo", testData); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	r, err := s.OpenReader("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+	data := make([]byte, len(testData))
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(data, testData) != 0 {
+		t.Fatal("expected", testData, "was", data)
+	}
+}
+
+func TestPutWithTTLExpires(t *testing.T) {
+	s := Store{Path: "foo", Expiry: NewFileExpiry("foo")}
+	defer s.Remove()
+
+	if err := s.PutWithTTL("foo", testData, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	if _, err := s.Get("foo"); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist for an already-expired element, got", err)
+	}
+}
+
+func TestPutWithTTLNotYetExpired(t *testing.T) {
+	s := Store{Path: "foo", Expiry: NewFileExpiry("foo")}
+	defer s.Remove()
+
+	if err := s.PutWithTTL("foo", testData, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	if retrieved, err := s.Get("foo"); err != nil {
+		t.Fatal(err)
+	} else if bytes.Compare(testData, retrieved) != 0 {
+		t.Fatal("expected", testData, "was", retrieved)
+	}
+}
+
+func TestGetRangeExpired(t *testing.T) {
+	s := Store{Path: "foo", Expiry: NewFileExpiry("foo")}
+	defer s.Remove()
+
+	if err := s.PutWithTTL("foo", testData, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	if _, err := s.GetRange("foo", 0, int64(len(testData))); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist for an already-expired element, got", err)
+	}
+
+	if _, err := s.OpenReader("foo"); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist for an already-expired element, got", err)
+	}
+}
+
+func TestJanitorEvictsPooledHandle(t *testing.T) {
+	s := Store{Path: "foo", Expiry: NewFileExpiry("foo")}
+	defer s.Remove()
+
+	if err := s.PutWithTTL("foo", testData, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+	s.Expiry = nil // read once through GetRange to populate the handle pool before expiry applies
+
+	if _, err := s.GetRange("foo", 0, int64(len(testData))); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Expiry = NewFileExpiry("foo")
+	if err := s.Expiry.SetExpiry("foo", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.sweepExpired()
+
+	// bypass the expiry check itself so this only exercises whether the
+	// pooled handle (and the file behind it) is actually gone
+	s.Expiry = nil
+	if _, err := s.GetRange("foo", 0, int64(len(testData))); err == nil {
+		t.Fatal("expected GetRange to fail once the janitor has evicted the pooled handle and removed the file")
+	}
+}
+
+func TestJanitorSweep(t *testing.T) {
+	inv := NewDefaultInventory()
+	cache := NewCacheAll()
+	s := Store{Path: "foo", Expiry: NewFileExpiry("foo"), Inventory: inv, Cache: cache}
+	defer s.Remove()
+
+	if err := s.PutWithTTL("foo", testData, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Sync()
+
+	stop := s.StartJanitor(10 * time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for inv.Has("foo") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if inv.Has("foo") {
+		t.Fatal("expected janitor to forget foo from the inventory")
+	}
+
+	if cache.Get("foo") != nil {
+		t.Fatal("expected janitor to evict foo from the cache")
+	}
+
+	if _, err := s.get("foo"); err == nil {
+		t.Fatal("expected janitor to remove foo from storage")
+	}
+}
+
 func benchGet(b *testing.B, s *Store, key string, data []byte) {
 	if err := s.Put(key, data); err != nil {
 		b.Fatal(err)
@@ -0,0 +1,18 @@
+package stor
+
+import (
+	"io"
+)
+
+// Storage is the persistence backend for a Store. A Store keeps its
+// caching, inventory, limiter, and in-transfer logic regardless of which
+// Storage implementation is plugged in; Storage is only responsible for
+// getting bytes in and out by hash.
+type Storage interface {
+	Put(hash string, r io.Reader) error
+	Get(hash string) (io.ReadCloser, error)
+	Delete(hash string) error
+	// Iterate calls fn for every hash known to the storage, stopping
+	// early if fn returns false
+	Iterate(fn func(hash string) bool) error
+}